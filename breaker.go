@@ -10,6 +10,7 @@ import (
 var (
 	ErrIsOpen                        = errors.New("breaker is open")
 	ErrHalfOpenButExceedRequestLimit = errors.New("breaker is half open, but current request exceed limit")
+	ErrCallTimeout                   = errors.New("call exceeded CallTimeout")
 
 	DefaultOpenStateExpiry            = time.Minute
 	DefaultFailureThreshold     int64 = 100
@@ -41,6 +42,8 @@ const (
 type StateChangeNotificationFunc func(Event)
 type HandleFunc func() (interface{}, error)
 type ShouldTripFunc func(Counts) bool
+type RequestNotificationFunc func()
+type RejectionNotificationFunc func(err error)
 
 type Option struct {
 	GenerationInterval   time.Duration
@@ -48,29 +51,99 @@ type Option struct {
 	FailureThreshold     int64
 	SuccessThreshold     int64
 	HalfOpenRequestLimit int64
-	OnStateChange        StateChangeNotificationFunc
-	shouldTrip           ShouldTripFunc
+	// FailureThresholdPercentage, when non-zero, switches the default trip
+	// policy to a failure-rate check: the breaker opens once Counts.Request
+	// has reached MinimumRequests and the failure ratio (as a percentage)
+	// reaches FailureThresholdPercentage. FailureThreshold is ignored in
+	// that case.
+	FailureThresholdPercentage int
+	// MinimumRequests is the sample size FailureThresholdPercentage requires
+	// before it is evaluated, so a handful of early failures can't trip a
+	// low-traffic breaker on their own.
+	MinimumRequests int64
+	OnStateChange   StateChangeNotificationFunc
+	// ShouldTrip overrides the trip predicate evaluated while the breaker is
+	// closed. When nil, it defaults to the FailureThreshold /
+	// FailureThresholdPercentage logic above. Set it to express policies
+	// those two knobs can't, such as tripping on ConsecutiveFailures alone
+	// or a hybrid of consecutive and ratio-based signals.
+	ShouldTrip ShouldTripFunc
+	// IsSuccessful classifies a handler's returned error as a success or a
+	// failure for the breaker's bookkeeping. Defaults to err == nil; set it
+	// to e.g. treat context.Canceled or 4xx-mapped errors as successes while
+	// still counting 5xx responses and timeouts as failures.
+	IsSuccessful func(err error) bool
+	// CallTimeout, when non-zero, bounds how long Do waits for the handler.
+	// If it's exceeded, Do returns ErrCallTimeout and records a failure
+	// (through IsSuccessful) instead of letting a slow call run unbounded.
+	CallTimeout time.Duration
+	// OnRequest, OnSuccess and OnFailure fire on every accepted request and
+	// its outcome, in addition to OnStateChange, so callers can wire
+	// Prometheus counters (or similar) without racing the internal mutex or
+	// wrapping every Do/BeforeRequest call themselves. An outcome reported
+	// to AfterRequest for a stale generation is dropped and fires neither
+	// OnSuccess nor OnFailure.
+	OnRequest RequestNotificationFunc
+	OnSuccess RequestNotificationFunc
+	OnFailure RequestNotificationFunc
+	// OnRejected fires with ErrIsOpen or ErrHalfOpenButExceedRequestLimit
+	// whenever BeforeRequest short-circuits a request, so callers can track
+	// rejection totals separately from handled failures.
+	OnRejected RejectionNotificationFunc
 }
 
 type Counts struct {
-	Request int64
-	Success int64
-	Failure int64
+	Request              int64
+	Success              int64
+	Failure              int64
+	TotalSuccesses       int64
+	TotalFailures        int64
+	ConsecutiveSuccesses int64
+	ConsecutiveFailures  int64
 }
 
 func (c Counts) String() string {
-	return fmt.Sprintf("request: %d, success: %d, failure: %d",
-		c.Request, c.Success, c.Failure)
+	return fmt.Sprintf("request: %d, success: %d, failure: %d, total successes: %d, total failures: %d, consecutive successes: %d, consecutive failures: %d",
+		c.Request, c.Success, c.Failure, c.TotalSuccesses, c.TotalFailures, c.ConsecutiveSuccesses, c.ConsecutiveFailures)
 }
 
+func (c *Counts) onRequest() {
+	c.Request++
+}
+
+func (c *Counts) onSuccess() {
+	c.Success++
+	c.TotalSuccesses++
+	c.ConsecutiveSuccesses++
+	c.ConsecutiveFailures = 0
+}
+
+func (c *Counts) onFailure() {
+	c.Failure++
+	c.TotalFailures++
+	c.ConsecutiveFailures++
+	c.ConsecutiveSuccesses = 0
+}
+
+// reset clears the per-generation window (Request/Success/Failure) and the
+// consecutive streaks. TotalSuccesses/TotalFailures are lifetime counters
+// and are deliberately left untouched, so a generation rollover (or a
+// manual Reset) doesn't erase the numbers a Prometheus counter would have
+// scraped.
 func (c *Counts) reset() {
 	c.Request = 0
 	c.Success = 0
 	c.Failure = 0
+	c.ConsecutiveSuccesses = 0
+	c.ConsecutiveFailures = 0
 }
 
-func New(opt Option) *CircuitBreaker {
-	cb := new(CircuitBreaker)
+// NewTracking builds the standalone state machine behind a CircuitBreaker.
+// Callers that can't express their execution flow through Do (streaming
+// responses, connection pools, gRPC interceptors, async Redis pipelines, ...)
+// can drive it directly via BeforeRequest/AfterRequest.
+func NewTracking(opt Option) *Tracking {
+	t := new(Tracking)
 
 	if opt.OpenStateExpiry == 0 {
 		opt.OpenStateExpiry = DefaultOpenStateExpiry
@@ -96,166 +169,340 @@ func New(opt Option) *CircuitBreaker {
 		panic("half-open request limit should greater than success threshold")
 	}
 
+	if opt.OnStateChange == nil {
+		opt.OnStateChange = func(Event) {}
+	}
+
+	if opt.IsSuccessful == nil {
+		opt.IsSuccessful = func(err error) bool {
+			return err == nil
+		}
+	}
+
+	if opt.OnRequest == nil {
+		opt.OnRequest = func() {}
+	}
+
+	if opt.OnSuccess == nil {
+		opt.OnSuccess = func() {}
+	}
+
+	if opt.OnFailure == nil {
+		opt.OnFailure = func() {}
+	}
+
+	if opt.OnRejected == nil {
+		opt.OnRejected = func(err error) {}
+	}
+
 	// Default trip function
-	opt.shouldTrip = func(c Counts) bool {
-		return c.Failure >= opt.FailureThreshold
+	if opt.ShouldTrip == nil {
+		opt.ShouldTrip = func(c Counts) bool {
+			if opt.FailureThresholdPercentage > 0 {
+				if c.Request == 0 || c.Request < opt.MinimumRequests {
+					return false
+				}
+				return c.Failure*100/c.Request >= int64(opt.FailureThresholdPercentage)
+			}
+			return c.Failure >= opt.FailureThreshold
+		}
 	}
 
-	cb.state = Closed
-	cb.opt = opt
-	cb.generation = time.Now()
-	cb.moveToNextGeneration(cb.generation)
+	t.state = Closed
+	t.opt = opt
+	t.generation = time.Now()
+	t.stateChangedAt = t.generation
+	t.moveToNextGeneration(t.generation)
 
-	return cb
+	return t
 }
 
-type CircuitBreaker struct {
-	opt        Option
-	state      State
-	generation time.Time
-	mutex      sync.RWMutex
-	counts     Counts
+// Tracking holds the breaker state machine (counts, generation, transitions)
+// independent from any particular way of executing a request. Do is just a
+// thin wrapper over BeforeRequest/AfterRequest.
+type Tracking struct {
+	opt            Option
+	state          State
+	generation     time.Time
+	stateChangedAt time.Time
+	mutex          sync.RWMutex
+	counts         Counts
+}
+
+// Snapshot is an atomically-read view of a Tracking's state, meant for
+// observability: wiring it to Prometheus gauges/histograms doesn't require
+// racing the internal mutex or wrapping every Do call.
+type Snapshot struct {
+	State       State
+	Counts      Counts
+	Generation  time.Time
+	TimeInState time.Duration
+}
+
+// Option returns the (already defaulted) Option the Tracking was built
+// with, so wrappers around it — such as the generic CircuitBreaker in the
+// v2 subpackage — can reuse settings like IsSuccessful and CallTimeout
+// without re-implementing the defaulting logic in NewTracking.
+func (t *Tracking) Option() Option {
+	return t.opt
 }
 
 // Get current breaker state
-func (cb *CircuitBreaker) State() State {
-	cb.mutex.RLock()
-	defer cb.mutex.RUnlock()
+func (t *Tracking) State() State {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
 
-	return cb.state
+	return t.state
 }
 
 // Reset breaker to initial state
-func (cb *CircuitBreaker) Reset() {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+func (t *Tracking) Reset() {
+	t.mutex.Lock()
+	ev := t.changeState(time.Now(), Closed, ReasonManuallyReset)
+	opt := t.opt
+	t.mutex.Unlock()
 
-	cb.changeState(time.Now(), Closed, ReasonManuallyReset)
+	opt.OnStateChange(ev)
 }
 
-func (cb *CircuitBreaker) Counts() Counts {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+func (t *Tracking) Counts() Counts {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
 
-	return cb.counts
+	return t.counts
 }
 
-// The main part of breaker which execute logical handlers
-func (cb *CircuitBreaker) Do(handle HandleFunc) (interface{}, error) {
-	gen, err := cb.postStartHook()
-	if err != nil {
-		return nil, err
+// Snapshot atomically returns the breaker's current state, counts,
+// generation and time spent in the current state.
+func (t *Tracking) Snapshot() Snapshot {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return Snapshot{
+		State:       t.state,
+		Counts:      t.counts,
+		Generation:  t.generation,
+		TimeInState: time.Since(t.stateChangedAt),
 	}
-	defer func(g time.Time) {
-		// For panic
-		e := recover()
-		if e != nil {
-			// Once panic, regard as failed
-			cb.preStopHook(g, false)
-			panic(e)
-		}
-	}(gen)
-	// Execute it
-	resp, err := handle()
-	cb.preStopHook(gen, err == nil)
-	return resp, err
 }
 
-// Return generation to avoid execute handle until next generation
-func (cb *CircuitBreaker) postStartHook() (time.Time, error) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// BeforeRequest should be called before a request is attempted. It returns
+// the current generation, which must be passed back to AfterRequest so
+// outcomes from a stale generation are not counted, and ErrIsOpen or
+// ErrHalfOpenButExceedRequestLimit when the request should be rejected.
+//
+// OnStateChange, OnRequest and OnRejected are invoked after the internal
+// lock has been released, so it's safe for them to call back into the
+// breaker (e.g. State() or Snapshot() from a metrics hook).
+func (t *Tracking) BeforeRequest() (time.Time, error) {
+	t.mutex.Lock()
 
 	// Check state
 	now := time.Now()
-	tempState := cb.state
+	tempState := t.state
+	var changed *Event
 	switch tempState {
 	case Open:
 		// Open state expired, change to half open
-		if now.After(cb.generation) {
-			cb.changeState(now, HalfOpen, ReasonOpenStateExpired)
+		if now.After(t.generation) {
+			ev := t.changeState(now, HalfOpen, ReasonOpenStateExpired)
+			changed = &ev
 		}
 	case HalfOpen:
 		// Over success threshold, close breaker
-		if cb.counts.Success >= cb.opt.SuccessThreshold {
-			cb.changeState(now, Closed, ReasonReachThreshold)
+		if t.counts.Success >= t.opt.SuccessThreshold {
+			ev := t.changeState(now, Closed, ReasonReachThreshold)
+			changed = &ev
 		}
 	case Closed:
 		// over failure threshold, open the breaker
-		if cb.opt.shouldTrip(cb.counts) {
-			cb.changeState(now, Open, ReasonReachThreshold)
+		if t.opt.ShouldTrip(t.counts) {
+			ev := t.changeState(now, Open, ReasonReachThreshold)
+			changed = &ev
 		}
 	}
 
 	// State never changed
-	if now.After(cb.generation) {
-		cb.moveToNextGeneration(now)
+	if now.After(t.generation) {
+		t.moveToNextGeneration(now)
 	}
 
 	// Breaker is open, intercept all the requests
-	if cb.state == Open {
-		return cb.generation, ErrIsOpen
-	} else if cb.state == HalfOpen && cb.counts.Request > cb.opt.HalfOpenRequestLimit {
+	var rejected error
+	accepted := false
+	switch {
+	case t.state == Open:
+		rejected = ErrIsOpen
+	case t.state == HalfOpen && t.counts.Request > t.opt.HalfOpenRequestLimit:
 		// Half open but exceed the request limit
-		return cb.generation, ErrHalfOpenButExceedRequestLimit
+		rejected = ErrHalfOpenButExceedRequestLimit
+	default:
+		t.counts.onRequest()
+		accepted = true
 	}
 
-	cb.counts.Request++
-	return cb.generation, nil
+	gen := t.generation
+	opt := t.opt
+	t.mutex.Unlock()
+
+	if changed != nil {
+		opt.OnStateChange(*changed)
+	}
+	switch {
+	case rejected != nil:
+		opt.OnRejected(rejected)
+		return gen, rejected
+	case accepted:
+		opt.OnRequest()
+	}
+	return gen, nil
 }
 
-func (cb *CircuitBreaker) preStopHook(currentGeneration time.Time, handleSuccess bool) {
-	cb.mutex.Lock()
-	defer cb.mutex.Unlock()
+// AfterRequest should be called once a request started by BeforeRequest
+// completes, reporting the generation it was handed and whether it
+// succeeded.
+//
+// As with BeforeRequest, OnSuccess/OnFailure/OnStateChange are invoked
+// after the internal lock has been released.
+func (t *Tracking) AfterRequest(generation time.Time, success bool) {
+	t.mutex.Lock()
 
 	// Handled in previous generation should not count in this generation
-	if currentGeneration != cb.generation {
+	if generation != t.generation {
+		t.mutex.Unlock()
 		return
 	}
 
-	if !handleSuccess {
-		cb.counts.Failure++
+	if !success {
+		t.counts.onFailure()
 	} else {
-		cb.counts.Success++
+		t.counts.onSuccess()
 	}
 
-	currentState := cb.state
+	currentState := t.state
+	var changed *Event
 	// Handle state transaction
 	switch currentState {
 	case HalfOpen:
-		if !handleSuccess {
+		if !success {
 			// Open it if failed once
-			cb.changeState(time.Now(), Open, ReasonFailedOnHalfOpenState)
+			ev := t.changeState(time.Now(), Open, ReasonFailedOnHalfOpenState)
+			changed = &ev
 		}
 	}
+
+	opt := t.opt
+	t.mutex.Unlock()
+
+	if !success {
+		opt.OnFailure()
+	} else {
+		opt.OnSuccess()
+	}
+	if changed != nil {
+		opt.OnStateChange(*changed)
+	}
 }
 
-// Change to new state, will move to next generation and notify preset onStateChange handler
-func (cb *CircuitBreaker) changeState(now time.Time, newState State, reason string) {
-	current := cb.state
-	cb.state = newState
-	cb.moveToNextGeneration(now)
-	cb.opt.OnStateChange(Event{
+// Change to new state and move to the next generation, returning the Event
+// describing the transition. The caller is responsible for notifying
+// OnStateChange once it has released t.mutex.
+func (t *Tracking) changeState(now time.Time, newState State, reason string) Event {
+	current := t.state
+	t.state = newState
+	t.stateChangedAt = now
+	t.moveToNextGeneration(now)
+	return Event{
 		When:   now,
 		From:   current,
 		To:     newState,
 		Reason: reason,
-	})
+	}
 }
 
 // Reset counts
-func (cb *CircuitBreaker) resetCounts() {
-	cb.counts.reset()
+func (t *Tracking) resetCounts() {
+	t.counts.reset()
 }
 
 // Move to nex generation according to current state
-func (cb *CircuitBreaker) moveToNextGeneration(now time.Time) {
+func (t *Tracking) moveToNextGeneration(now time.Time) {
 	// State degeneration
-	cb.resetCounts()
-	switch cb.state {
+	t.resetCounts()
+	switch t.state {
 	case Open:
-		cb.generation = now.Add(cb.opt.OpenStateExpiry)
+		t.generation = now.Add(t.opt.OpenStateExpiry)
 	case HalfOpen, Closed:
-		cb.generation = now.Add(cb.opt.GenerationInterval)
+		t.generation = now.Add(t.opt.GenerationInterval)
+	}
+}
+
+func New(opt Option) *CircuitBreaker {
+	return &CircuitBreaker{Tracking: NewTracking(opt)}
+}
+
+// CircuitBreaker wraps Tracking with the HandleFunc closure model: it calls
+// BeforeRequest/AfterRequest around the handler so callers that just want to
+// guard a synchronous call don't have to.
+type CircuitBreaker struct {
+	*Tracking
+}
+
+// The main part of breaker which execute logical handlers
+func (cb *CircuitBreaker) Do(handle HandleFunc) (interface{}, error) {
+	gen, err := cb.BeforeRequest()
+	if err != nil {
+		return nil, err
+	}
+	defer func(g time.Time) {
+		// For panic
+		e := recover()
+		if e != nil {
+			// Once panic, regard as failed
+			cb.AfterRequest(g, false)
+			panic(e)
+		}
+	}(gen)
+
+	if cb.opt.CallTimeout > 0 {
+		return cb.doWithTimeout(gen, handle)
+	}
+
+	// Execute it
+	resp, err := handle()
+	cb.AfterRequest(gen, cb.opt.IsSuccessful(err))
+	return resp, err
+}
+
+// doWithTimeout runs handle under opt.CallTimeout. If the handler doesn't
+// return in time, the slow call is left running (HandleFunc has no way to
+// cancel it) and the request is recorded as a failure; the caller sees
+// ErrCallTimeout rather than whatever the eventually-returned error is.
+func (cb *CircuitBreaker) doWithTimeout(gen time.Time, handle HandleFunc) (interface{}, error) {
+	type callResult struct {
+		resp interface{}
+		err  error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		// If handle panics after we've already given up on it, there is no
+		// caller goroutine left to re-panic on, so recover to keep a slow
+		// handler from crashing the process. A panic before the timeout is
+		// recovered too: nothing is sent on done, so Do reports the call as
+		// ErrCallTimeout once CallTimeout elapses.
+		defer func() {
+			recover()
+		}()
+		resp, err := handle()
+		done <- callResult{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		cb.AfterRequest(gen, cb.opt.IsSuccessful(r.err))
+		return r.resp, r.err
+	case <-time.After(cb.opt.CallTimeout):
+		cb.AfterRequest(gen, cb.opt.IsSuccessful(ErrCallTimeout))
+		return nil, ErrCallTimeout
 	}
 }
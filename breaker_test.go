@@ -0,0 +1,293 @@
+package circuit_breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var errBoom = errors.New("boom")
+
+func TestPercentageTripWithoutMinimumRequests(t *testing.T) {
+	cb := New(Option{
+		FailureThresholdPercentage: 10,
+		OnStateChange:              func(Event) {},
+	})
+
+	if _, err := cb.Do(func() (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error on first request: %v", err)
+	}
+}
+
+// TestTotalCountsSurviveGenerationRollover asserts that TotalSuccesses and
+// TotalFailures are true lifetime counters: unlike Success/Failure, they
+// must not be zeroed when the sliding window rolls over into a new
+// generation.
+func TestTotalCountsSurviveGenerationRollover(t *testing.T) {
+	cb := New(Option{
+		GenerationInterval: time.Millisecond,
+		OnStateChange:      func(Event) {},
+	})
+
+	if _, err := cb.Do(func() (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Trigger a generation rollover via BeforeRequest; this request's own
+	// success is then the only one in the new window, and the second one in
+	// the lifetime totals.
+	if _, err := cb.Do(func() (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	counts := cb.Counts()
+	if counts.Success != 1 {
+		t.Fatalf("expected Success to reset to 1 after rollover, got %d", counts.Success)
+	}
+	if counts.TotalSuccesses != 2 {
+		t.Fatalf("expected TotalSuccesses to survive rollover as 2, got %d", counts.TotalSuccesses)
+	}
+}
+
+// TestConsecutiveCountsResetOnOppositeOutcome covers the behavior chunk0-3
+// added ShouldTrip for: ConsecutiveFailures/ConsecutiveSuccesses should
+// track streaks, each reset to zero by the opposite outcome.
+func TestConsecutiveCountsResetOnOppositeOutcome(t *testing.T) {
+	cb := New(Option{OnStateChange: func(Event) {}})
+
+	doErr := func(fail bool) {
+		_, _ = cb.Do(func() (interface{}, error) {
+			if fail {
+				return nil, errBoom
+			}
+			return nil, nil
+		})
+	}
+
+	doErr(true)
+	doErr(true)
+	doErr(true)
+	if c := cb.Counts(); c.ConsecutiveFailures != 3 || c.ConsecutiveSuccesses != 0 {
+		t.Fatalf("expected 3 consecutive failures, got %+v", c)
+	}
+
+	doErr(false)
+	if c := cb.Counts(); c.ConsecutiveFailures != 0 || c.ConsecutiveSuccesses != 1 {
+		t.Fatalf("expected consecutive failures reset by a success, got %+v", c)
+	}
+}
+
+// TestTrackingStandaloneAcrossGoroutines drives Tracking directly instead
+// of through CircuitBreaker.Do, the motivating use case for splitting it
+// out: a caller that starts a request, hands the generation off somewhere
+// else (here, another goroutine standing in for e.g. a gRPC interceptor or
+// an async Redis reply handler), and reports the outcome once it's known.
+func TestTrackingStandaloneAcrossGoroutines(t *testing.T) {
+	tr := NewTracking(Option{OnStateChange: func(Event) {}})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tr.AfterRequest(gen, true)
+	}()
+	<-done
+
+	if c := tr.Counts(); c.Success != 1 {
+		t.Fatalf("expected Success=1 after AfterRequest, got %+v", c)
+	}
+}
+
+// TestTrackingAfterRequestDropsStaleGeneration covers the guard
+// BeforeRequest/AfterRequest rely on for decoupled usage: if the
+// generation rolls over before AfterRequest is finally called (e.g. the
+// caller held on to a request far longer than GenerationInterval), that
+// late report must not be applied to the new generation's counts.
+func TestTrackingAfterRequestDropsStaleGeneration(t *testing.T) {
+	tr := NewTracking(Option{
+		GenerationInterval: time.Millisecond,
+		OnStateChange:      func(Event) {},
+	})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Roll the generation over without ever reporting the first request.
+	if _, err := tr.BeforeRequest(); err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+
+	tr.AfterRequest(gen, true)
+
+	if c := tr.Counts(); c.Success != 0 {
+		t.Fatalf("expected the stale AfterRequest to be dropped, got Success=%d", c.Success)
+	}
+}
+
+// TestCallTimeoutRecoversLateHandlerPanic ensures a handler that panics
+// after CallTimeout has already elapsed doesn't crash the test process.
+func TestCallTimeoutRecoversLateHandlerPanic(t *testing.T) {
+	cb := New(Option{
+		CallTimeout:   time.Millisecond,
+		OnStateChange: func(Event) {},
+	})
+
+	resp, err := cb.Do(func() (interface{}, error) {
+		time.Sleep(20 * time.Millisecond)
+		panic("boom")
+	})
+	if err != ErrCallTimeout {
+		t.Fatalf("expected ErrCallTimeout, got %v", err)
+	}
+	if resp != nil {
+		t.Fatalf("expected nil response, got %v", resp)
+	}
+
+	// Give the leaked goroutine time to panic and (hopefully) be recovered;
+	// if it isn't, the test binary crashes instead of failing cleanly.
+	time.Sleep(30 * time.Millisecond)
+}
+
+// TestHooksDoNotDeadlockOnReentrantCalls exercises the exact pattern the
+// hooks are meant to support: a hook reading the breaker's own state (e.g.
+// to set a Prometheus enum gauge). If a hook fires while the internal
+// mutex is still held, this call never returns.
+func TestHooksDoNotDeadlockOnReentrantCalls(t *testing.T) {
+	var cb *CircuitBreaker
+	cb = New(Option{
+		OnRequest: func() { cb.State() },
+		OnSuccess: func() { cb.Snapshot() },
+		OnFailure: func() { cb.Counts() },
+		OnRejected: func(error) {
+			cb.State()
+		},
+		OnStateChange: func(Event) {
+			cb.State()
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cb.Do(func() (interface{}, error) {
+			return nil, nil
+		})
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Do deadlocked when a hook called back into the breaker")
+	}
+}
+
+// TestHooksFireExpectedCounts asserts OnRequest/OnSuccess/OnFailure are
+// called exactly as many times as Do accepts/succeeds/fails a handler —
+// the counts a Prometheus counter would be built on.
+func TestHooksFireExpectedCounts(t *testing.T) {
+	var requests, successes, failures int
+	cb := New(Option{
+		OnRequest:     func() { requests++ },
+		OnSuccess:     func() { successes++ },
+		OnFailure:     func() { failures++ },
+		OnStateChange: func(Event) {},
+	})
+
+	for i := 0; i < 2; i++ {
+		if _, err := cb.Do(func() (interface{}, error) {
+			return nil, nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cb.Do(func() (interface{}, error) {
+			return nil, errBoom
+		}); err == nil {
+			t.Fatal("expected the handler's error to propagate")
+		}
+	}
+
+	if requests != 5 {
+		t.Fatalf("expected OnRequest to fire 5 times, got %d", requests)
+	}
+	if successes != 2 {
+		t.Fatalf("expected OnSuccess to fire 2 times, got %d", successes)
+	}
+	if failures != 3 {
+		t.Fatalf("expected OnFailure to fire 3 times, got %d", failures)
+	}
+}
+
+// TestOnRejectedReceivesCorrectSentinel asserts OnRejected is called with
+// ErrIsOpen while the breaker is Open and with
+// ErrHalfOpenButExceedRequestLimit once the half-open request budget is
+// spent — not just that it fires at all.
+func TestOnRejectedReceivesCorrectSentinel(t *testing.T) {
+	var rejections []error
+	tr := NewTracking(Option{
+		FailureThreshold:     1,
+		SuccessThreshold:     1,
+		HalfOpenRequestLimit: 1,
+		OpenStateExpiry:      time.Millisecond,
+		GenerationInterval:   time.Hour,
+		OnStateChange:        func(Event) {},
+		OnRejected: func(err error) {
+			rejections = append(rejections, err)
+		},
+	})
+
+	gen, err := tr.BeforeRequest()
+	if err != nil {
+		t.Fatalf("unexpected rejection: %v", err)
+	}
+	tr.AfterRequest(gen, false)
+
+	// Closed -> Open: the failure above meets FailureThreshold, so this
+	// call's own BeforeRequest trips the breaker and is itself rejected.
+	if _, err := tr.BeforeRequest(); err != ErrIsOpen {
+		t.Fatalf("expected ErrIsOpen, got %v", err)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	// Open -> HalfOpen: OpenStateExpiry has passed, so these two requests
+	// are let through (the limit check is "> HalfOpenRequestLimit", so the
+	// count must exceed, not just reach, the budget of 1).
+	for i := 0; i < 2; i++ {
+		if _, err := tr.BeforeRequest(); err != nil {
+			t.Fatalf("expected half-open probe %d to be accepted, got %v", i, err)
+		}
+	}
+
+	// A third concurrent half-open request exceeds the budget.
+	if _, err := tr.BeforeRequest(); err != ErrHalfOpenButExceedRequestLimit {
+		t.Fatalf("expected ErrHalfOpenButExceedRequestLimit, got %v", err)
+	}
+
+	if len(rejections) != 2 {
+		t.Fatalf("expected 2 rejections recorded, got %d: %v", len(rejections), rejections)
+	}
+	if rejections[0] != ErrIsOpen {
+		t.Fatalf("expected first rejection to be ErrIsOpen, got %v", rejections[0])
+	}
+	if rejections[1] != ErrHalfOpenButExceedRequestLimit {
+		t.Fatalf("expected second rejection to be ErrHalfOpenButExceedRequestLimit, got %v", rejections[1])
+	}
+}
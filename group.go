@@ -0,0 +1,95 @@
+package circuit_breaker
+
+import "sync"
+
+// Group lazily constructs and caches a CircuitBreaker per key from a shared
+// Option template. It's meant for callers that need one breaker per
+// endpoint, host, or tenant (e.g. an HTTP client fanning out to many
+// upstreams) without hand-rolling a sync.Map and duplicating option
+// boilerplate for every key.
+type Group struct {
+	opt      Option
+	mutex    sync.RWMutex
+	breakers map[string]*CircuitBreaker
+}
+
+// NewGroup builds a Group that creates breakers on demand using opt.
+func NewGroup(opt Option) *Group {
+	return &Group{
+		opt:      opt,
+		breakers: make(map[string]*CircuitBreaker),
+	}
+}
+
+// Get returns the breaker for name, creating it from the Group's Option
+// template if this is the first time name has been seen.
+func (g *Group) Get(name string) *CircuitBreaker {
+	g.mutex.RLock()
+	cb, ok := g.breakers[name]
+	g.mutex.RUnlock()
+	if ok {
+		return cb
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	// Another goroutine may have created it while we waited for the lock.
+	if cb, ok := g.breakers[name]; ok {
+		return cb
+	}
+
+	cb = New(g.opt)
+	g.breakers[name] = cb
+	return cb
+}
+
+// Names lists the keys of breakers currently held by the Group.
+func (g *Group) Names() []string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+
+	names := make([]string, 0, len(g.breakers))
+	for name := range g.breakers {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Range calls f for every breaker currently held by the Group, in no
+// particular order, stopping early if f returns false. It's meant for
+// metrics scraping, so f is called outside the Group's lock.
+func (g *Group) Range(f func(name string, cb *CircuitBreaker) bool) {
+	g.mutex.RLock()
+	snapshot := make(map[string]*CircuitBreaker, len(g.breakers))
+	for name, cb := range g.breakers {
+		snapshot[name] = cb
+	}
+	g.mutex.RUnlock()
+
+	for name, cb := range snapshot {
+		if !f(name, cb) {
+			return
+		}
+	}
+}
+
+// RemoveIdle drops breakers that are Closed and have seen no requests in
+// their current generation, and returns how many were removed. A breaker's
+// request count is only reset when a later BeforeRequest rolls its
+// generation over, so a breaker that served requests and then received no
+// more keeps a non-zero count and is not removed.
+func (g *Group) RemoveIdle() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	removed := 0
+	for name, cb := range g.breakers {
+		snap := cb.Snapshot()
+		if snap.State == Closed && snap.Counts.Request == 0 {
+			delete(g.breakers, name)
+			removed++
+		}
+	}
+	return removed
+}
@@ -0,0 +1,98 @@
+package circuit_breaker
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestGroupGetIsSingleFlight exercises the double-checked locking in
+// Group.Get: concurrent callers asking for the same name must all observe
+// the same *CircuitBreaker, not one-per-goroutine.
+func TestGroupGetIsSingleFlight(t *testing.T) {
+	g := NewGroup(Option{OnStateChange: func(Event) {}})
+
+	const goroutines = 50
+	breakers := make([]*CircuitBreaker, goroutines)
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		i := i
+		go func() {
+			defer wg.Done()
+			breakers[i] = g.Get("upstream-a")
+		}()
+	}
+	wg.Wait()
+
+	first := breakers[0]
+	for i, cb := range breakers {
+		if cb != first {
+			t.Fatalf("goroutine %d got a different breaker for the same name", i)
+		}
+	}
+
+	if names := g.Names(); len(names) != 1 || names[0] != "upstream-a" {
+		t.Fatalf("expected a single tracked name, got %v", names)
+	}
+}
+
+func TestGroupRemoveIdle(t *testing.T) {
+	g := NewGroup(Option{OnStateChange: func(Event) {}})
+
+	idle := g.Get("idle")
+	_ = idle
+	active := g.Get("active")
+	if _, err := active.Do(func() (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	removed := g.RemoveIdle()
+	if removed != 1 {
+		t.Fatalf("expected to remove 1 idle breaker, removed %d", removed)
+	}
+
+	names := g.Names()
+	if len(names) != 1 || names[0] != "active" {
+		t.Fatalf("expected only 'active' to remain, got %v", names)
+	}
+}
+
+// TestGroupRemoveIdleKeepsTrippedBreaker guards against reading State() and
+// Counts() as two separate, non-atomic acquisitions: tripping a breaker
+// resets Counts.Request to 0 as part of the transition to Open, so a naive
+// "Request == 0" idle check must also see the up-to-date State from the
+// very same read, via Snapshot(), or it will delete a breaker that just
+// tripped under real failures.
+func TestGroupRemoveIdleKeepsTrippedBreaker(t *testing.T) {
+	g := NewGroup(Option{
+		FailureThreshold: 1,
+		OnStateChange:    func(Event) {},
+	})
+
+	cb := g.Get("flaky")
+	// The first failing call records the failure; ShouldTrip is evaluated
+	// on the next BeforeRequest, so it takes a second call to observe Open.
+	for i := 0; i < 2; i++ {
+		if _, err := cb.Do(func() (interface{}, error) {
+			return nil, errBoom
+		}); err == nil {
+			t.Fatal("expected the handler's error, then ErrIsOpen")
+		}
+	}
+	if cb.State() != Open {
+		t.Fatalf("expected breaker to trip to Open, got %v", cb.State())
+	}
+	if cb.Counts().Request != 0 {
+		t.Fatalf("expected Request to reset on trip, got %d", cb.Counts().Request)
+	}
+
+	if removed := g.RemoveIdle(); removed != 0 {
+		t.Fatalf("expected RemoveIdle to leave the tripped breaker alone, removed %d", removed)
+	}
+	if names := g.Names(); len(names) != 1 || names[0] != "flaky" {
+		t.Fatalf("expected 'flaky' to still be tracked, got %v", names)
+	}
+}
@@ -0,0 +1,90 @@
+// Package v2 provides a generics-based CircuitBreaker[T] on top of the
+// same Tracking state machine used by the root, interface{}-based package.
+// It exists so callers can get a compile-time-checked handler signature
+// without a type assertion on every Do result; the untyped API at the
+// repo root is kept as-is for existing callers.
+package v2
+
+import (
+	"time"
+
+	breaker "github.com/oif/circuit-breaker"
+)
+
+// Option is the same Option used by the untyped breaker: generics only
+// change how Do's result is typed, not how the breaker is configured.
+type Option = breaker.Option
+
+// CircuitBreaker wraps breaker.Tracking with a typed HandleFunc, so callers
+// don't need to type-assert the interface{} result of the untyped Do.
+type CircuitBreaker[T any] struct {
+	*breaker.Tracking
+}
+
+func New[T any](opt Option) *CircuitBreaker[T] {
+	return &CircuitBreaker[T]{Tracking: breaker.NewTracking(opt)}
+}
+
+// The main part of breaker which execute logical handlers
+func (cb *CircuitBreaker[T]) Do(handle func() (T, error)) (T, error) {
+	var zero T
+
+	gen, err := cb.BeforeRequest()
+	if err != nil {
+		return zero, err
+	}
+	defer func(g time.Time) {
+		// For panic
+		e := recover()
+		if e != nil {
+			// Once panic, regard as failed
+			cb.AfterRequest(g, false)
+			panic(e)
+		}
+	}(gen)
+
+	opt := cb.Option()
+	if opt.CallTimeout > 0 {
+		return cb.doWithTimeout(gen, opt, handle)
+	}
+
+	// Execute it
+	resp, err := handle()
+	cb.AfterRequest(gen, opt.IsSuccessful(err))
+	return resp, err
+}
+
+// doWithTimeout mirrors the untyped breaker's CallTimeout handling: if handle
+// doesn't return in time, the slow call is left running and the request is
+// recorded as a failure, with the caller seeing breaker.ErrCallTimeout
+// rather than whatever the eventually-returned error is.
+func (cb *CircuitBreaker[T]) doWithTimeout(gen time.Time, opt Option, handle func() (T, error)) (T, error) {
+	var zero T
+
+	type callResult struct {
+		resp T
+		err  error
+	}
+	done := make(chan callResult, 1)
+	go func() {
+		// If handle panics after we've already given up on it, there is no
+		// caller goroutine left to re-panic on, so recover to keep a slow
+		// handler from crashing the process. A panic before the timeout is
+		// recovered too: nothing is sent on done, so Do reports the call as
+		// breaker.ErrCallTimeout once CallTimeout elapses.
+		defer func() {
+			recover()
+		}()
+		resp, err := handle()
+		done <- callResult{resp, err}
+	}()
+
+	select {
+	case r := <-done:
+		cb.AfterRequest(gen, opt.IsSuccessful(r.err))
+		return r.resp, r.err
+	case <-time.After(opt.CallTimeout):
+		cb.AfterRequest(gen, opt.IsSuccessful(breaker.ErrCallTimeout))
+		return zero, breaker.ErrCallTimeout
+	}
+}
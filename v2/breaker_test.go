@@ -0,0 +1,45 @@
+package v2
+
+import (
+	"errors"
+	"testing"
+
+	breaker "github.com/oif/circuit-breaker"
+)
+
+func TestTypedDoReturnsZeroValueOnRejection(t *testing.T) {
+	cb := New[string](Option{
+		FailureThreshold: 1,
+		OnStateChange:    func(breaker.Event) {},
+	})
+
+	if _, err := cb.Do(func() (string, error) {
+		return "", errors.New("boom")
+	}); err == nil {
+		t.Fatal("expected the first call to fail and trip the breaker")
+	}
+
+	resp, err := cb.Do(func() (string, error) {
+		return "unused", nil
+	})
+	if err != breaker.ErrIsOpen {
+		t.Fatalf("expected ErrIsOpen once tripped, got %v", err)
+	}
+	if resp != "" {
+		t.Fatalf("expected the zero value for T on rejection, got %q", resp)
+	}
+}
+
+func TestTypedDoReturnsHandlerValue(t *testing.T) {
+	cb := New[int](Option{OnStateChange: func(breaker.Event) {}})
+
+	resp, err := cb.Do(func() (int, error) {
+		return 42, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp != 42 {
+		t.Fatalf("expected 42, got %d", resp)
+	}
+}